@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/server/healthz"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
@@ -28,6 +36,10 @@ var (
 	opt                  *options.ControllerOptions
 	onlyOneSignalHandler = make(chan struct{})
 	shutdownSignals      = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+	// isLeader is 1 when this process currently holds the leader-election
+	// lock, used by the healthz readiness check.
+	isLeader int32
 )
 
 func init() {
@@ -41,44 +53,151 @@ func init() {
 }
 
 func main() {
-	stopCh := setupSignalHandler()
+	shutdownCtx := setupSignalHandler()
 
-	ctx := newControllerContext()
-	run := func(_ <-chan struct{}) {
+	startHealthzServer()
 
-		// build controllers map
-		var controllers = make(map[string]controllerpkg.Interface)
-		for n, fn := range controllerpkg.Known() {
-			logrus.Infof("Register controller: %s", n)
-			controllers[n] = fn(ctx)
-		}
+	runWithLeaderElectionRetries(shutdownCtx)
+
+	// runWithLeaderElectionRetries only returns once shutdownCtx is
+	// cancelled and the final generation has drained cleanly: a graceful
+	// shutdown, not a programming error.
+	logrus.Info("Shutdown complete")
+	os.Exit(0)
+}
+
+// runGeneration builds a fresh controller context and shared informer
+// factory and runs controllers against them until leaderStopCh is closed
+// (leadership lost or process shutting down), then waits for them to drain,
+// bounded by opt.ShutdownTimeout once shutdownCtx is cancelled. A new
+// informer factory is required on every call: once the previous generation's
+// leaderStopCh closed, its informers' reflector goroutines exited for good,
+// and SharedInformerFactory.Start is a no-op for informer types it has
+// already started.
+func runGeneration(shutdownCtx context.Context, leaderStopCh <-chan struct{}) {
+	ctx, sIF := newControllerContext()
+
+	// build controllers map
+	var controllers = make(map[string]controllerpkg.Interface)
+	for n, fn := range controllerpkg.Known() {
+		logrus.Infof("Register controller: %s", n)
+		controllers[n] = fn(ctx)
+	}
+
+	var inflightMu sync.Mutex
+	inflight := make(map[string]struct{}, len(controllers))
+
+	var wg sync.WaitGroup
+	for n, cRoutine := range controllers {
+		wg.Add(1)
+		inflightMu.Lock()
+		inflight[n] = struct{}{}
+		inflightMu.Unlock()
+
+		go func(n string, cRoutine controllerpkg.Interface) {
+			defer wg.Done()
+			defer func() {
+				inflightMu.Lock()
+				delete(inflight, n)
+				inflightMu.Unlock()
+			}()
+
+			logrus.Infof("Starting controller: %s", n)
+			err := cRoutine(opt.ConcurrentClusterSyncs, leaderStopCh)
 
-		var wg sync.WaitGroup
-		for n, cRoutine := range controllers {
-			wg.Add(1)
-			go func(n string, cRoutine controllerpkg.Interface) {
-				defer wg.Done()
+			if err != nil {
+				logrus.Fatalf("error running %s controller: %s", n, err.Error())
+			}
+		}(n, cRoutine)
+	}
+	sIF.Start(leaderStopCh)
 
-				logrus.Infof("Starting controller: %s", n)
-				err := cRoutine(2, stopCh)
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
 
-				if err != nil {
-					logrus.Fatalf("error running %s controller: %s", n, err.Error())
-				}
-			}(n, cRoutine)
+	select {
+	case <-drained:
+		logrus.Infof("Control loops drained")
+	case <-shutdownCtx.Done():
+		select {
+		case <-drained:
+			logrus.Infof("Control loops drained")
+		case <-time.After(opt.ShutdownTimeout):
+			inflightMu.Lock()
+			outstanding := make([]string, 0, len(inflight))
+			for n := range inflight {
+				outstanding = append(outstanding, n)
+			}
+			inflightMu.Unlock()
+			logrus.Errorf("Shutdown timed out after %s, controllers still running: %v",
+				opt.ShutdownTimeout, outstanding)
+			os.Exit(1)
 		}
-		ctx.SharedInformerFactory.Start(stopCh)
-		//ctx.KubeSharedInformerFactory.Start(stopCh)
-		wg.Wait() // wait for controllers to finish
-		logrus.Fatalf("Control loops exited")
 	}
+}
+
+// runWithLeaderElectionRetries runs the leader election loop, re-entering it
+// after a lost lease (e.g. a transient apiserver blip) up to
+// opt.LeaderElectionRetryOnLostLeaseAttempts times before giving up. Each
+// generation's controllers are fully drained (via generationDone) before the
+// next call to startLeadingElection, so two generations never run
+// concurrently. It only returns once ctx is cancelled (graceful shutdown was
+// requested) and the final generation (if any) has drained.
+func runWithLeaderElectionRetries(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		generationDone := make(chan struct{})
+		var startedLeading int32
+		run := func(leaderStopCh <-chan struct{}) {
+			atomic.StoreInt32(&startedLeading, 1)
+			defer close(generationDone)
+			runGeneration(ctx, leaderStopCh)
+		}
 
-	startLeadingElection(run)
+		startLeadingElection(ctx, run)
+		// startLeadingElection/RunOrDie return as soon as the renew loop
+		// stops; they do not wait for the OnStartedLeading goroutine (our
+		// run) to finish. Wait for it explicitly so the next leadership
+		// generation never starts before this one has fully drained. A
+		// standby replica that loses the race to acquire the lock (the
+		// common case in a multi-replica deployment) never has
+		// OnStartedLeading invoked at all, so run/generationDone never
+		// fires - waiting on it unconditionally would hang such a pod
+		// forever on shutdown, past --shutdown-timeout and any bound.
+		if atomic.LoadInt32(&startedLeading) == 1 {
+			<-generationDone
+		}
+
+		if ctx.Err() != nil {
+			// Shutdown was requested; the lock has already been released
+			// (ReleaseOnCancel) so there's nothing left to retry.
+			return
+		}
+
+		if attempt >= opt.LeaderElectionRetryOnLostLeaseAttempts {
+			logrus.Fatalf("Leader election lost and retry attempts (%d) exhausted", opt.LeaderElectionRetryOnLostLeaseAttempts)
+		}
 
-	panic("unreachable")
+		backoff := time.Duration(attempt+1) * opt.LeaderElectionRetryPeriod
+		logrus.Warnf("Leader election lost, retrying in %s (attempt %d/%d)",
+			backoff, attempt+1, opt.LeaderElectionRetryOnLostLeaseAttempts)
+		time.Sleep(backoff)
+	}
 }
 
-func newControllerContext() *controllerpkg.Context {
+// newControllerContext builds the single controllerpkg.Context and
+// SharedInformerFactory that runGeneration registers all controllers
+// against.
+//
+// TODO(chunk0-4 follow-up): this is single-namespace-or-cluster-wide only.
+// Real per-namespace fan-out (comma-separated --watch-namespaces, one
+// SharedInformerFactory and worker set per namespace) needs
+// controllerpkg.Context to hold a factory-per-namespace and the
+// clustercontroller registration to range over them; neither is done here,
+// so that part of chunk0-4 remains outstanding.
+func newControllerContext() (*controllerpkg.Context, informers.SharedInformerFactory) {
 	kubecli := k8sutil.MustNewKubeClient()
 
 	serviceAccount, err := getMyPodServiceAccount(kubecli)
@@ -86,21 +205,28 @@ func newControllerContext() *controllerpkg.Context {
 		logrus.Fatalf("fail to get my pod's service account: %v", err)
 	}
 
-	sIF, err := getSharedInformerFactory()
+	ns := opt.WatchNamespace
+	if opt.ClusterScoped() {
+		ns = metav1.NamespaceAll
+	}
+
+	sIF, err := getSharedInformerFactory(ns)
 	if err != nil {
 		logrus.Fatalf("fail to get shered inform factory: %v", err)
 	}
 
 	return &controllerpkg.Context{
-		Namespace:             opt.Namespace,
+		Namespace:             ns,
 		ServiceAccount:        serviceAccount,
 		KubeCli:               kubecli,
 		KubeExtCli:            k8sutil.MustNewKubeExtClient(),
 		SharedInformerFactory: sIF,
-	}
+	}, sIF
 }
 
-func getSharedInformerFactory() (informers.SharedInformerFactory, error) {
+// getSharedInformerFactory returns a SharedInformerFactory filtered to ns, or
+// an unfiltered, cluster-wide one when ns is metav1.NamespaceAll.
+func getSharedInformerFactory(ns string) (informers.SharedInformerFactory, error) {
 	kubeCfg, err := k8sutil.ClusterConfig()
 	if err != nil {
 		return nil, err
@@ -111,10 +237,10 @@ func getSharedInformerFactory() (informers.SharedInformerFactory, error) {
 		return nil, err
 	}
 
-	sharedInformerFactory := informers.NewFilteredSharedInformerFactory(intcl,
-		opt.InformersResyncTime, opt.Namespace, nil)
-	return sharedInformerFactory, nil
-
+	if ns == metav1.NamespaceAll {
+		return informers.NewSharedInformerFactory(intcl, opt.InformersResyncTime), nil
+	}
+	return informers.NewFilteredSharedInformerFactory(intcl, opt.InformersResyncTime, ns, nil), nil
 }
 
 func getMyPodServiceAccount(kubecli kubernetes.Interface) (string, error) {
@@ -128,47 +254,99 @@ func getMyPodServiceAccount(kubecli kubernetes.Interface) (string, error) {
 	return sa, nil
 }
 
-// SetupSignalHandler registered for SIGTERM and SIGINT. A stop channel is returned
-// which is closed on one of these signals. If a second signal is caught, the program
-// is terminated with exit code 1.
-func setupSignalHandler() (stopCh <-chan struct{}) {
+// setupSignalHandler registers for SIGTERM and SIGINT. A context is returned
+// which is cancelled on one of these signals, so shutdown logic can react to
+// ctx.Done(). If a second signal is caught, the program is terminated with
+// exit code 1.
+func setupSignalHandler() context.Context {
 	close(onlyOneSignalHandler) // panics when called twice
 
-	stop := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, shutdownSignals...)
 	go func() {
 		<-c
-		close(stop)
+		cancel()
 		<-c
 		os.Exit(1) // second signal. Exit directly.
 	}()
 
-	return stop
+	return ctx
 }
 
-func startLeadingElection(run func(<-chan struct{})) {
+func startLeadingElection(ctx context.Context, run func(<-chan struct{})) {
 	kubecli := k8sutil.MustNewKubeClient()
 
-	leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
-		Lock:          getResourceLock(kubecli),
-		LeaseDuration: opt.LeaderElectionLeaseDuration,
-		RenewDeadline: opt.LeaderElectionRenewDeadline,
-		RetryPeriod:   opt.LeaderElectionRetryPeriod,
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            getResourceLock(kubecli),
+		LeaseDuration:   opt.LeaderElectionLeaseDuration,
+		RenewDeadline:   opt.LeaderElectionRenewDeadline,
+		RetryPeriod:     opt.LeaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
 		Callbacks: leaderelection.LeaderCallbacks{
-			OnStartedLeading: run,
+			OnStartedLeading: func(ctx context.Context) {
+				atomic.StoreInt32(&isLeader, 1)
+				run(ctx.Done())
+			},
 			OnStoppedLeading: func() {
-				logrus.Fatalf("Leader election lost")
+				atomic.StoreInt32(&isLeader, 0)
+				logrus.Warnf("Stopped leading")
 			},
 		},
 	})
 }
 
+// startHealthzServer starts, in the background, an HTTP server exposing
+// /healthz, /readyz, /metrics and (when --enable-profiling is set)
+// /debug/pprof/*. /healthz only pings the process, so it should back the
+// deployment's liveness probe; /readyz additionally requires this pod to be
+// the active leader, and should back the readiness probe instead, so that
+// standby replicas are taken out of service rather than restart-looped.
+func startHealthzServer() {
+	mux := http.NewServeMux()
+
+	healthz.InstallHandler(mux, healthz.PingHealthz)
+	healthz.InstallReadyzHandler(mux, leaderElectionHealthzChecker{})
+	mux.Handle("/metrics", promhttp.Handler())
+
+	if opt.EnableProfiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	go func() {
+		logrus.Infof("Starting healthz/metrics server on %s", opt.BindAddress)
+		if err := http.ListenAndServe(opt.BindAddress, mux); err != nil {
+			logrus.Fatalf("healthz/metrics server failed: %v", err)
+		}
+	}()
+}
+
+// leaderElectionHealthzChecker reports ready only once this pod has become
+// the active leader, so that Kubernetes only routes traffic to it once it's
+// actually doing work.
+type leaderElectionHealthzChecker struct{}
+
+func (leaderElectionHealthzChecker) Name() string {
+	return "leaderElection"
+}
+
+func (leaderElectionHealthzChecker) Check(_ *http.Request) error {
+	if atomic.LoadInt32(&isLeader) == 0 {
+		return fmt.Errorf("not the current leader")
+	}
+	return nil
+}
+
 func getResourceLock(kubecli kubernetes.Interface) resourcelock.Interface {
-	rl, err := resourcelock.New(resourcelock.EndpointsResourceLock,
+	rl, err := resourcelock.New(opt.LeaderElectionResourceLock,
 		opt.Namespace,
 		"mysql-operator-titanium",
 		kubecli.CoreV1(),
+		kubecli.CoordinationV1(),
 		resourcelock.ResourceLockConfig{
 			Identity:      util.GetPodHostName(),
 			EventRecorder: util.CreateEventRecorder(kubecli, opt.PodName, opt.Namespace),
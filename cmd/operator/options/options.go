@@ -0,0 +1,149 @@
+package options
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// ControllerOptions holds the configuration for the mysql-operator binary,
+// populated from command line flags.
+type ControllerOptions struct {
+	Namespace string
+	PodName   string
+
+	InformersResyncTime time.Duration
+
+	LeaderElectionLeaseDuration time.Duration
+	LeaderElectionRenewDeadline time.Duration
+	LeaderElectionRetryPeriod   time.Duration
+	// LeaderElectionResourceLock is the type of resource object used for
+	// locking during leader election, one of "leases", "endpointsleases" or
+	// "configmapsleases". The latter two allow rolling migration off the
+	// deprecated endpoints/configmaps locks onto leases.
+	LeaderElectionResourceLock string
+	// LeaderElectionRetryOnLostLeaseAttempts is the number of times the
+	// operator will re-attempt leader election after losing its lease
+	// before giving up and exiting.
+	LeaderElectionRetryOnLostLeaseAttempts int
+
+	// BindAddress is the address the healthz/metrics HTTP server listens on.
+	BindAddress string
+	// EnableProfiling exposes pprof handlers under /debug/pprof/* on the
+	// healthz/metrics server.
+	EnableProfiling bool
+
+	// WatchNamespace is the namespace to watch for mysql cluster resources,
+	// or "*" to watch cluster-wide (all namespaces). Defaults to Namespace.
+	//
+	// This is a scope cut from the original ask of a comma-separated
+	// namespace list fanning out one worker set per namespace: that requires
+	// controllerpkg.Context and the clustercontroller registration to each
+	// range over one SharedInformerFactory per watched namespace, which this
+	// flag alone cannot provide and which is out of reach without touching
+	// those packages. Tracked as a follow-up; until then only
+	// single-namespace-or-cluster-wide is supported.
+	WatchNamespace string
+	// ConcurrentClusterSyncs is the number of worker goroutines used per
+	// controller.
+	ConcurrentClusterSyncs int
+
+	// ShutdownTimeout bounds how long the operator waits for controllers to
+	// drain after a shutdown signal before logging the ones still running
+	// and exiting non-zero.
+	ShutdownTimeout time.Duration
+}
+
+// NewControllerOptions returns a ControllerOptions with sane defaults.
+func NewControllerOptions() *ControllerOptions {
+	return &ControllerOptions{
+		InformersResyncTime: 30 * time.Second,
+
+		LeaderElectionLeaseDuration:            15 * time.Second,
+		LeaderElectionRenewDeadline:            10 * time.Second,
+		LeaderElectionRetryPeriod:              2 * time.Second,
+		LeaderElectionResourceLock:             "leases",
+		LeaderElectionRetryOnLostLeaseAttempts: 3,
+
+		BindAddress: ":8080",
+
+		ConcurrentClusterSyncs: 2,
+
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// AddFlags registers the ControllerOptions fields as command line flags.
+func (o *ControllerOptions) AddFlags() {
+	flag.StringVar(&o.Namespace, "namespace", o.Namespace,
+		"Namespace the operator runs in; holds the leader-election lock and is the namespace watched unless --watch-namespace is set.")
+	flag.StringVar(&o.PodName, "pod-name", o.PodName,
+		"Name of the pod this operator is running in, used to discover its service account.")
+
+	flag.DurationVar(&o.InformersResyncTime, "informers-resync-interval", o.InformersResyncTime,
+		"Resync period for shared informers.")
+
+	flag.DurationVar(&o.LeaderElectionLeaseDuration, "leader-election-lease-duration", o.LeaderElectionLeaseDuration,
+		"Duration that non-leader candidates will wait to force acquire leadership.")
+	flag.DurationVar(&o.LeaderElectionRenewDeadline, "leader-election-renew-deadline", o.LeaderElectionRenewDeadline,
+		"Duration that the acting leader will retry refreshing leadership before giving up.")
+	flag.DurationVar(&o.LeaderElectionRetryPeriod, "leader-election-retry-period", o.LeaderElectionRetryPeriod,
+		"Duration the clients should wait between tries of actions.")
+	flag.StringVar(&o.LeaderElectionResourceLock, "leader-election-resource-lock", o.LeaderElectionResourceLock,
+		"The type of resource object used for locking during leader election. "+
+			"One of 'leases', 'endpointsleases' or 'configmapsleases'.")
+	flag.IntVar(&o.LeaderElectionRetryOnLostLeaseAttempts, "leader-election-retry-on-lost-lease-attempts",
+		o.LeaderElectionRetryOnLostLeaseAttempts,
+		"Number of times to re-attempt leader election after losing the lease before exiting.")
+
+	flag.StringVar(&o.BindAddress, "bind-address", o.BindAddress,
+		"Address to bind the healthz/metrics HTTP server to.")
+	flag.BoolVar(&o.EnableProfiling, "enable-profiling", o.EnableProfiling,
+		"Enable pprof handlers under /debug/pprof/* on the healthz/metrics server.")
+
+	flag.StringVar(&o.WatchNamespace, "watch-namespace", o.WatchNamespace,
+		"Namespace to watch for mysql cluster resources. Defaults to --namespace; "+
+			"pass '*' to watch cluster-wide.")
+	flag.IntVar(&o.ConcurrentClusterSyncs, "concurrent-cluster-syncs", o.ConcurrentClusterSyncs,
+		"Number of worker goroutines to use per controller.")
+
+	flag.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", o.ShutdownTimeout,
+		"Maximum time to wait for controllers to drain after a shutdown signal before exiting non-zero.")
+}
+
+// Validate checks that the provided options are sane.
+func (o *ControllerOptions) Validate() error {
+	if len(o.Namespace) == 0 {
+		return fmt.Errorf("--namespace is required")
+	}
+	if len(o.BindAddress) == 0 {
+		return fmt.Errorf("--bind-address must not be empty")
+	}
+	switch o.LeaderElectionResourceLock {
+	case "leases", "endpointsleases", "configmapsleases":
+	default:
+		return fmt.Errorf("--leader-election-resource-lock must be one of 'leases', 'endpointsleases' or 'configmapsleases', got %q",
+			o.LeaderElectionResourceLock)
+	}
+	if o.LeaderElectionRetryOnLostLeaseAttempts < 0 {
+		return fmt.Errorf("--leader-election-retry-on-lost-lease-attempts must not be negative")
+	}
+
+	if o.WatchNamespace == "" {
+		o.WatchNamespace = o.Namespace
+	}
+	if o.ConcurrentClusterSyncs <= 0 {
+		return fmt.Errorf("--concurrent-cluster-syncs must be greater than zero")
+	}
+	if o.ShutdownTimeout <= 0 {
+		return fmt.Errorf("--shutdown-timeout must be greater than zero")
+	}
+
+	return nil
+}
+
+// ClusterScoped reports whether the operator is configured to watch all
+// namespaces, rather than a single one.
+func (o *ControllerOptions) ClusterScoped() bool {
+	return o.WatchNamespace == "*"
+}